@@ -0,0 +1,74 @@
+// Package consumer provides a subscriber helper for runner responses
+// published with header-based correlation (see the function package's
+// correlationHeaders), validating messages before handing them to callers.
+package consumer
+
+import (
+	"errors"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+const (
+	headerInboxId     = "Kubiya-Inbox-Id"
+	headerRunner      = "Kubiya-Runner"
+	headerTraceId     = "Kubiya-Trace-Id"
+	headerTraceParent = "traceparent"
+	headerTraceState  = "tracestate"
+)
+
+// ErrMissingInboxId is returned when a message has no Kubiya-Inbox-Id header.
+var ErrMissingInboxId = errors.New("consumer: missing Kubiya-Inbox-Id header")
+
+// ErrMissingRunner is returned when a message has no Kubiya-Runner header.
+var ErrMissingRunner = errors.New("consumer: missing Kubiya-Runner header")
+
+// Envelope is a validated runner response message.
+type Envelope struct {
+	InboxId     string
+	Runner      string
+	TraceId     string
+	TraceParent string
+	TraceState  string
+	Data        []byte
+}
+
+// FromMsg validates msg's headers and extracts an Envelope, rejecting
+// messages that don't carry the correlation headers a runner response must
+// have.
+func FromMsg(msg *nats.Msg) (Envelope, error) {
+	inboxId := msg.Header.Get(headerInboxId)
+	if inboxId == "" {
+		return Envelope{}, ErrMissingInboxId
+	}
+	runner := msg.Header.Get(headerRunner)
+	if runner == "" {
+		return Envelope{}, ErrMissingRunner
+	}
+	return Envelope{
+		InboxId:     inboxId,
+		Runner:      runner,
+		TraceId:     msg.Header.Get(headerTraceId),
+		TraceParent: msg.Header.Get(headerTraceParent),
+		TraceState:  msg.Header.Get(headerTraceState),
+		Data:        msg.Data,
+	}, nil
+}
+
+// Handler processes a validated runner response.
+type Handler func(Envelope) error
+
+// Subscribe wires fn to subject, discarding and logging any message that
+// fails header validation instead of handing it to fn.
+func Subscribe(nc *nats.Conn, subject string, fn Handler) (*nats.Subscription, error) {
+	return nc.Subscribe(subject, func(msg *nats.Msg) {
+		env, err := FromMsg(msg)
+		if err != nil {
+			logger.Warn().Err(err).Str("subject", subject).Msg("consumer: rejecting malformed message")
+			return
+		}
+		if err := fn(env); err != nil {
+			logger.Error().Err(err).Str("inbox_id", env.InboxId).Msg("consumer: handler error")
+		}
+	})
+}
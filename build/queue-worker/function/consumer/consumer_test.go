@@ -0,0 +1,76 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestFromMsg(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  nats.Header
+		data    []byte
+		wantErr error
+	}{
+		{
+			name:    "missing inbox id",
+			header:  nats.Header{headerRunner: []string{"runner-a"}},
+			wantErr: ErrMissingInboxId,
+		},
+		{
+			name:    "missing runner",
+			header:  nats.Header{headerInboxId: []string{"inbox-1"}},
+			wantErr: ErrMissingRunner,
+		},
+		{
+			name: "valid message with trace headers",
+			header: nats.Header{
+				headerInboxId:     []string{"inbox-1"},
+				headerRunner:      []string{"runner-a"},
+				headerTraceId:     []string{"trace-1"},
+				headerTraceParent: []string{"00-trace-parent-01"},
+				headerTraceState:  []string{"vendor=1"},
+			},
+			data: []byte("payload"),
+		},
+		{
+			name: "valid message without trace headers",
+			header: nats.Header{
+				headerInboxId: []string{"inbox-2"},
+				headerRunner:  []string{"runner-b"},
+			},
+			data: []byte("payload"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &nats.Msg{Header: tt.header, Data: tt.data}
+			env, err := FromMsg(msg)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FromMsg() err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromMsg() unexpected err: %v", err)
+			}
+			if env.InboxId != tt.header.Get(headerInboxId) {
+				t.Errorf("got InboxId=%q, want %q", env.InboxId, tt.header.Get(headerInboxId))
+			}
+			if env.Runner != tt.header.Get(headerRunner) {
+				t.Errorf("got Runner=%q, want %q", env.Runner, tt.header.Get(headerRunner))
+			}
+			if env.TraceId != tt.header.Get(headerTraceId) {
+				t.Errorf("got TraceId=%q, want %q", env.TraceId, tt.header.Get(headerTraceId))
+			}
+			if string(env.Data) != string(tt.data) {
+				t.Errorf("got Data=%q, want %q", env.Data, tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// logger is the package-level zerolog.Logger Subscribe logs rejected
+// messages and handler errors through. Configured the same way as the
+// function package's logger, from LOG_LEVEL and LOG_FORMAT, so a subscriber
+// process gets the same JSON/console toggle without a code change.
+var logger = newLogger()
+
+func newLogger() zerolog.Logger {
+	zerolog.SetGlobalLevel(parseLevel(os.Getenv("LOG_LEVEL")))
+
+	var out io.Writer = os.Stdout
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "console") {
+		out = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	}
+	return zerolog.New(out).With().Timestamp().Logger()
+}
+
+func parseLevel(raw string) zerolog.Level {
+	if raw == "" {
+		return zerolog.InfoLevel
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(raw))
+	if err != nil {
+		return zerolog.InfoLevel
+	}
+	return level
+}
@@ -0,0 +1,109 @@
+package function
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// compressionThreshold is the marshaled output size above which the output
+// is gzip-compressed as a whole before being split into chunks.
+const compressionThreshold = 32 * 1024
+
+// maxChunkSize bounds each published chunk well under NATS core's default
+// ~1 MiB max_payload, leaving headroom for the envelope's own JSON framing.
+const maxChunkSize = 512 * 1024
+
+// responseEnvelope is the wire format for a runner response. Output is
+// arbitrary JSON and, unlike the raw text the handler used to publish, is no
+// longer silently dropped. Large payloads are split across several
+// envelopes sharing the same InboxId with increasing Seq, so the consumer
+// can reassemble them in order.
+type responseEnvelope struct {
+	InboxId     string `json:"inbox_id"`
+	Runner      string `json:"runner"`
+	ContentType string `json:"content_type"`
+	Encoding    string `json:"encoding,omitempty"`
+	Seq         int    `json:"seq"`
+	Total       int    `json:"total"`
+	Payload     []byte `json:"payload"`
+}
+
+// buildEnvelopes marshals output, compresses it when it's large enough to
+// benefit, and splits the result into ordered envelopes that each fit
+// comfortably under NATS's payload limit.
+func buildEnvelopes(inboxId, runner string, output interface{}) ([]responseEnvelope, error) {
+	raw, err := json.Marshal(output)
+	if err != nil {
+		return nil, fmt.Errorf("marshal output: %w", err)
+	}
+
+	encoding := ""
+	if len(raw) > compressionThreshold {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(raw); err != nil {
+			return nil, fmt.Errorf("gzip output: %w", err)
+		}
+		if err := gw.Close(); err != nil {
+			return nil, fmt.Errorf("gzip output: %w", err)
+		}
+		raw = buf.Bytes()
+		encoding = "gzip"
+	}
+
+	chunks := chunkPayload(raw, maxChunkSize)
+	envelopes := make([]responseEnvelope, len(chunks))
+	for i, chunk := range chunks {
+		envelopes[i] = responseEnvelope{
+			InboxId:     inboxId,
+			Runner:      runner,
+			ContentType: "application/json",
+			Encoding:    encoding,
+			Seq:         i,
+			Total:       len(chunks),
+			Payload:     chunk,
+		}
+	}
+	return envelopes, nil
+}
+
+func chunkPayload(payload []byte, size int) [][]byte {
+	if len(payload) == 0 {
+		return [][]byte{payload}
+	}
+	chunks := make([][]byte, 0, len(payload)/size+1)
+	for len(payload) > 0 {
+		n := size
+		if n > len(payload) {
+			n = len(payload)
+		}
+		chunks = append(chunks, payload[:n])
+		payload = payload[n:]
+	}
+	return chunks
+}
+
+// publishEnvelopes publishes each chunk on subject through pub, the same
+// configured backend (NATS, JetStream, or AMQP) the rest of the response
+// goes through. Every chunk carries the envelope's real InboxId as replyTo,
+// so the Kubiya-Inbox-Id header (or AMQP CorrelationId) always identifies
+// the response regardless of how many chunks it was split into. A separate
+// per-chunk id, derived from InboxId and Seq, is attached to the context so
+// a JetStream consumer can still dedup redeliveries of an individual chunk.
+func publishEnvelopes(ctx context.Context, pub Publisher, subject string, envelopes []responseEnvelope) error {
+	for _, e := range envelopes {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("marshal envelope chunk %d/%d: %w", e.Seq, e.Total, err)
+		}
+		chunkId := fmt.Sprintf("%s.%d", e.InboxId, e.Seq)
+		chunkCtx := withDedupeID(ctx, chunkId)
+		if err := pub.Publish(chunkCtx, subject, e.InboxId, data); err != nil {
+			return fmt.Errorf("publish envelope chunk %d/%d: %w", e.Seq, e.Total, err)
+		}
+	}
+	return nil
+}
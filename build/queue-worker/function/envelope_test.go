@@ -0,0 +1,124 @@
+package function
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestChunkPayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    []byte
+		size       int
+		wantChunks int
+		wantLast   int
+	}{
+		{"empty payload", []byte{}, 10, 1, 0},
+		{"smaller than size", []byte("hello"), 10, 1, 5},
+		{"exact multiple", bytes.Repeat([]byte("a"), 20), 10, 2, 10},
+		{"crosses boundary by one byte", bytes.Repeat([]byte("a"), 21), 10, 3, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkPayload(tt.payload, tt.size)
+			if len(chunks) != tt.wantChunks {
+				t.Fatalf("got %d chunks, want %d", len(chunks), tt.wantChunks)
+			}
+			last := chunks[len(chunks)-1]
+			if len(last) != tt.wantLast {
+				t.Fatalf("last chunk has %d bytes, want %d", len(last), tt.wantLast)
+			}
+			var reassembled []byte
+			for _, c := range chunks {
+				reassembled = append(reassembled, c...)
+			}
+			if !bytes.Equal(reassembled, tt.payload) {
+				t.Fatalf("reassembled payload does not match input")
+			}
+		})
+	}
+}
+
+func TestBuildEnvelopes(t *testing.T) {
+	t.Run("small output is not compressed and fits in one envelope", func(t *testing.T) {
+		envelopes, err := buildEnvelopes("inbox-1", "runner-a", map[string]string{"hello": "world"})
+		if err != nil {
+			t.Fatalf("buildEnvelopes: %v", err)
+		}
+		if len(envelopes) != 1 {
+			t.Fatalf("got %d envelopes, want 1", len(envelopes))
+		}
+		e := envelopes[0]
+		if e.Encoding != "" {
+			t.Fatalf("got encoding %q, want none", e.Encoding)
+		}
+		if e.InboxId != "inbox-1" || e.Runner != "runner-a" {
+			t.Fatalf("got InboxId=%q Runner=%q, want inbox-1/runner-a", e.InboxId, e.Runner)
+		}
+		if e.Seq != 0 || e.Total != 1 {
+			t.Fatalf("got Seq=%d Total=%d, want 0/1", e.Seq, e.Total)
+		}
+	})
+
+	t.Run("output above compressionThreshold is gzip compressed", func(t *testing.T) {
+		big := strings.Repeat("x", compressionThreshold+1)
+		envelopes, err := buildEnvelopes("inbox-2", "runner-b", big)
+		if err != nil {
+			t.Fatalf("buildEnvelopes: %v", err)
+		}
+		for _, e := range envelopes {
+			if e.Encoding != "gzip" {
+				t.Fatalf("got encoding %q, want gzip", e.Encoding)
+			}
+		}
+
+		var payload []byte
+		for _, e := range envelopes {
+			payload = append(payload, e.Payload...)
+		}
+		gr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("gzip.NewReader: %v", err)
+		}
+		defer gr.Close()
+		decompressed, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatalf("read gzip: %v", err)
+		}
+		if !bytes.Contains(decompressed, []byte(big)) {
+			t.Fatalf("decompressed output does not contain the original string")
+		}
+	})
+
+	t.Run("output above maxChunkSize is split across envelopes with increasing Seq", func(t *testing.T) {
+		// Incompressible so compression doesn't shrink it back under
+		// maxChunkSize and mask the chunking behavior under test.
+		raw := make([]byte, maxChunkSize*2+1)
+		rand.New(rand.NewSource(1)).Read(raw)
+		big := base64.StdEncoding.EncodeToString(raw)
+		envelopes, err := buildEnvelopes("inbox-3", "runner-c", big)
+		if err != nil {
+			t.Fatalf("buildEnvelopes: %v", err)
+		}
+		if len(envelopes) < 2 {
+			t.Fatalf("got %d envelopes, want at least 2", len(envelopes))
+		}
+		for i, e := range envelopes {
+			if e.Seq != i {
+				t.Fatalf("envelope %d has Seq=%d, want %d", i, e.Seq, i)
+			}
+			if e.Total != len(envelopes) {
+				t.Fatalf("envelope %d has Total=%d, want %d", i, e.Total, len(envelopes))
+			}
+			if e.InboxId != "inbox-3" {
+				t.Fatalf("envelope %d has InboxId=%q, want inbox-3", i, e.InboxId)
+			}
+		}
+	})
+}
@@ -2,67 +2,213 @@ package function
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	nats "github.com/nats-io/nats.go"
 	handler "github.com/openfaas/templates-sdk/go-http"
 )
 
+const (
+	natsCredsFile     = "/var/openfaas/secrets/nts-tkn"
+	natsReconnectWait = 2 * time.Second
+	natsPingInterval  = 20 * time.Second
+)
+
+var (
+	ncOnce sync.Once
+	nc     *nats.Conn
+	ncErr  error
+)
+
 type MessageBody struct {
 	InboxId string      `json:"inbox_id"`
 	Output  interface{} `json:"output"`
 	Runner  string      `json:"runner"`
+	// Stream opts this message into durable JetStream delivery; when set (or
+	// KUBIYA_JETSTREAM=1 is exported) the response is published with acks,
+	// retries, and server-side dedup instead of a best-effort core publish.
+	Stream string `json:"stream,omitempty"`
+}
+
+// natsConn returns the process-wide NATS connection, establishing it on first
+// use. Re-connecting on every invocation is too expensive (TLS handshake +
+// NKey auth per request), so the connection is created once and kept alive
+// across invocations, with the client itself handling reconnects.
+//
+// registerShutdownHandler is invoked here too (not just from getPublisher),
+// since the stream/KUBIYA_JETSTREAM=1 path reaches this connection via
+// jsContext without ever going through getPublisher, and the handler must
+// be installed regardless of which publish path a deployment actually uses.
+func natsConn() (*nats.Conn, error) {
+	ncOnce.Do(func() {
+		registerShutdownHandlerFn()
+		nc, ncErr = natsConnectFn(
+			"tls://connect.ngs.global",
+			nats.UserCredentials(natsCredsFile),
+			nats.Name("kubiya-runner"),
+			nats.RetryOnFailedConnect(true),
+			nats.ReconnectWait(natsReconnectWait),
+			nats.MaxReconnects(-1),
+			nats.PingInterval(natsPingInterval),
+			nats.DisconnectErrHandler(func(c *nats.Conn, err error) {
+				logger.Warn().Err(err).Msg("nats disconnected")
+			}),
+			nats.ReconnectHandler(func(c *nats.Conn) {
+				logger.Info().Str("url", c.ConnectedUrl()).Msg("nats reconnected")
+			}),
+			nats.ClosedHandler(func(c *nats.Conn) {
+				logger.Info().Msg("nats connection closed")
+			}),
+		)
+	})
+	return nc, ncErr
+}
+
+// natsConnectFn is a package-level indirection to nats.Connect, so tests can
+// stub the dial and exercise natsConn's once-only/shutdown-registration
+// behavior without reaching the real NGS endpoint.
+var natsConnectFn = nats.Connect
+
+// drainNATS drains the shared connection on shutdown so in-flight publishes
+// complete instead of being dropped by an abrupt Close.
+func drainNATS() error {
+	if nc == nil {
+		return nil
+	}
+	return nc.Drain()
 }
 
 // Handle a function invocation
-func Handle(req handler.Request) (handler.Response, error) {
-	// print the request body as a string
-	fmt.Println("Received body: " + string(req.Body))
+func Handle(req handler.Request) (resp handler.Response, err error) {
+	start := time.Now()
+	var inboxId, runner string
+
+	defer func() {
+		event := logger.Info()
+		if err != nil || resp.StatusCode >= http.StatusBadRequest {
+			event = logger.Error().Err(err)
+		}
+		event.
+			Str("inbox_id", inboxId).
+			Str("runner", runner).
+			Int("bytes", len(req.Body)).
+			Int64("duration_ms", time.Since(start).Milliseconds()).
+			Msg("invocation complete")
+	}()
+
+	logger.Debug().Bytes("body", req.Body).Msg("received request body")
+
 	msg := "default message"
 	if len(req.Body) > 0 {
 		msg = string(bytes.TrimSpace(req.Body))
 	}
-	nc, err := nats.Connect("tls://connect.ngs.global", nats.UserCredentials("/var/openfaas/secrets/nts-tkn"))
-	if err != nil {
-		errMsg := fmt.Sprintf("can not connect to nats: %s", err)
-		log.Printf(errMsg)
-		r := handler.Response{
-			Body:       []byte(errMsg),
-			StatusCode: http.StatusInternalServerError,
-		}
-		return r, err
-	}
-	defer nc.Close()
+
+	ctx := withTraceContext(context.Background(), req.Header.Get(HeaderTraceParent), req.Header.Get(HeaderTraceState))
+
 	var messageBody MessageBody
-	log.Printf("request body: %s", req.Body)
-	jsonErr := json.Unmarshal(req.Body, &messageBody)
-	if jsonErr != nil {
-		log.Printf("Error unmarshalling request body: %s", jsonErr)
-		r := handler.Response{
+	if unmarshalErr := json.Unmarshal(req.Body, &messageBody); unmarshalErr != nil {
+		err = unmarshalErr
+		resp = handler.Response{
 			Body:       []byte("Error unmarshalling request body"),
 			StatusCode: http.StatusInternalServerError,
 		}
-		return r, err
+		return
 	}
-	inboxId := messageBody.InboxId
-	runner := messageBody.Runner
-	log.Printf("Publishing %d bytes to: %q\n", len(msg), messageBody.InboxId)
-
-	err = nc.PublishRequest(runner+".response", inboxId, []byte(msg))
-	if err != nil {
-		log.Printf("Error publishing to nats: %s", err)
-		r := handler.Response{
-			Body:       []byte(fmt.Sprintf("can not publish to NATS: %s", err)),
-			StatusCode: http.StatusInternalServerError,
+	inboxId = messageBody.InboxId
+	runner = messageBody.Runner
+
+	// The stream path never needs the generic, KUBIYA_BUS-selected Publisher:
+	// it's served entirely by jetStreamForStreamFn, whose own
+	// KUBIYA_BUS=amqp guard would otherwise never run if a generic publisher
+	// lookup failed first.
+	if messageBody.Stream != "" || os.Getenv("KUBIYA_JETSTREAM") == "1" {
+		streamPub, jsErr := jetStreamForStreamFn()
+		if jsErr != nil {
+			resp = handler.Response{
+				Body:       []byte(fmt.Sprintf("jetstream publish failed (stream=%q): %s", messageBody.Stream, jsErr)),
+				StatusCode: publishStatusCode(jsErr, http.StatusBadGateway),
+			}
+			return
 		}
-		return r, err
+
+		if messageBody.Output != nil {
+			streamCtx := withExpectStream(ctx, messageBody.Stream)
+			resp, err = publishOutput(streamCtx, streamPub, inboxId, runner, messageBody.Output, fmt.Sprintf(" via stream %q", messageBody.Stream))
+			return
+		}
+
+		ack, ackErr := streamPub.PublishStream(ctx, runner+".response", inboxId, messageBody.Stream, []byte(msg))
+		if ackErr != nil {
+			resp = handler.Response{
+				Body:       []byte(fmt.Sprintf("jetstream publish failed (stream=%q): %s", messageBody.Stream, ackErr)),
+				StatusCode: publishStatusCode(ackErr, http.StatusBadGateway),
+			}
+			return
+		}
+		resp = handler.Response{
+			Body:       []byte(fmt.Sprintf("Published %d bytes to stream %q at sequence %d", len(msg), ack.Stream, ack.Sequence)),
+			StatusCode: http.StatusOK,
+		}
+		return
 	}
 
-	return handler.Response{
+	pub, pubErr := getPublisherFn()
+	if pubErr != nil {
+		resp = handler.Response{
+			Body:       []byte(fmt.Sprintf("message bus unavailable: %v", pubErr)),
+			StatusCode: http.StatusServiceUnavailable,
+		}
+		return
+	}
+
+	if messageBody.Output != nil {
+		resp, err = publishOutput(ctx, pub, inboxId, runner, messageBody.Output, "")
+		return
+	}
+
+	if pubErr := pub.Publish(ctx, runner+".response", inboxId, []byte(msg)); pubErr != nil {
+		err = pubErr
+		resp = handler.Response{
+			Body:       []byte(fmt.Sprintf("can not publish message: %s", pubErr)),
+			StatusCode: publishStatusCode(pubErr, http.StatusInternalServerError),
+		}
+		return
+	}
+
+	resp = handler.Response{
 		Body:       []byte(fmt.Sprintf("Published %d bytes to: %q", len(msg), "runner")),
 		StatusCode: http.StatusOK,
+	}
+	return
+}
+
+// publishOutput builds envelopes for output and publishes them through pub,
+// shared by the stream and non-stream paths since both chunk Output the
+// same way and only differ in which Publisher and context they use. suffix
+// is appended to the success message (e.g. to name the stream it went
+// through).
+func publishOutput(ctx context.Context, pub Publisher, inboxId, runner string, output interface{}, suffix string) (handler.Response, error) {
+	envelopes, envErr := buildEnvelopes(inboxId, runner, output)
+	if envErr != nil {
+		return handler.Response{
+			Body:       []byte(fmt.Sprintf("can not build response envelope: %s", envErr)),
+			StatusCode: http.StatusInternalServerError,
+		}, envErr
+	}
+	if pubErr := publishEnvelopes(ctx, pub, runner+".response", envelopes); pubErr != nil {
+		return handler.Response{
+			Body:       []byte(fmt.Sprintf("can not publish output: %s", pubErr)),
+			StatusCode: publishStatusCode(pubErr, http.StatusInternalServerError),
+		}, pubErr
+	}
+	return handler.Response{
+		Body:       []byte(fmt.Sprintf("Published output to %q in %d chunk(s)%s", runner, len(envelopes), suffix)),
+		StatusCode: http.StatusOK,
 	}, nil
 }
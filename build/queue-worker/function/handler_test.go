@@ -0,0 +1,132 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+)
+
+// fakePublisher is a Publisher stub that records what it was asked to
+// publish, so tests can assert on payloads without a real message bus.
+type fakePublisher struct {
+	published []responseEnvelope
+	err       error
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, subject, replyTo string, payload []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	var env responseEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return err
+	}
+	f.published = append(f.published, env)
+	return nil
+}
+
+// fakeStreamPublisher additionally implements streamPublisher, so it can
+// stand in for jetStreamForStreamFn's result.
+type fakeStreamPublisher struct {
+	fakePublisher
+	ack *jetStreamAck
+}
+
+func (f *fakeStreamPublisher) PublishStream(ctx context.Context, subject, inboxId, stream string, payload []byte) (*jetStreamAck, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.ack, nil
+}
+
+func withStubbedPublishers(t *testing.T, pub Publisher, streamPub streamPublisher, streamErr error) {
+	t.Helper()
+	origGetPublisher, origJetStreamForStream := getPublisherFn, jetStreamForStreamFn
+	getPublisherFn = func() (Publisher, error) { return pub, nil }
+	jetStreamForStreamFn = func() (streamPublisher, error) { return streamPub, streamErr }
+	t.Cleanup(func() {
+		getPublisherFn = origGetPublisher
+		jetStreamForStreamFn = origJetStreamForStream
+	})
+}
+
+func TestHandleStreamWithOutput(t *testing.T) {
+	stream := &fakeStreamPublisher{ack: &jetStreamAck{Stream: "responses", Sequence: 1}}
+	withStubbedPublishers(t, &fakePublisher{}, stream, nil)
+
+	body, err := json.Marshal(MessageBody{
+		InboxId: "inbox-1",
+		Runner:  "runner-a",
+		Output:  map[string]string{"hello": "world"},
+		Stream:  "responses",
+	})
+	if err != nil {
+		t.Fatalf("marshal request body: %v", err)
+	}
+
+	resp, err := Handle(handler.Request{Body: body})
+	if err != nil {
+		t.Fatalf("Handle returned err: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d; body=%s", resp.StatusCode, http.StatusOK, resp.Body)
+	}
+
+	if len(stream.published) != 1 {
+		t.Fatalf("got %d envelopes published, want 1", len(stream.published))
+	}
+	var got map[string]string
+	if err := json.Unmarshal(stream.published[0].Payload, &got); err != nil {
+		t.Fatalf("unmarshal published payload: %v", err)
+	}
+	if got["hello"] != "world" {
+		t.Fatalf("Output was dropped: got payload %v, want {hello: world}", got)
+	}
+}
+
+func TestHandleStreamWithoutOutputStillPublishesRawMessage(t *testing.T) {
+	stream := &fakeStreamPublisher{ack: &jetStreamAck{Stream: "responses", Sequence: 7}}
+	withStubbedPublishers(t, &fakePublisher{}, stream, nil)
+
+	body, err := json.Marshal(MessageBody{InboxId: "inbox-2", Runner: "runner-b", Stream: "responses"})
+	if err != nil {
+		t.Fatalf("marshal request body: %v", err)
+	}
+
+	resp, err := Handle(handler.Request{Body: body})
+	if err != nil {
+		t.Fatalf("Handle returned err: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d; body=%s", resp.StatusCode, http.StatusOK, resp.Body)
+	}
+}
+
+func TestHandleStreamUnavailableDoesNotFallBackToGenericPublisher(t *testing.T) {
+	generic := &fakePublisher{}
+	withStubbedPublishers(t, generic, nil, ErrBusUnavailable)
+
+	body, err := json.Marshal(MessageBody{
+		InboxId: "inbox-3",
+		Runner:  "runner-c",
+		Output:  map[string]string{"hello": "world"},
+		Stream:  "responses",
+	})
+	if err != nil {
+		t.Fatalf("marshal request body: %v", err)
+	}
+
+	resp, err := Handle(handler.Request{Body: body})
+	if err != nil {
+		t.Fatalf("Handle returned err: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d; body=%s", resp.StatusCode, http.StatusServiceUnavailable, resp.Body)
+	}
+	if len(generic.published) != 0 {
+		t.Fatalf("generic publisher should not have been used when stream is unavailable, got %d publishes", len(generic.published))
+	}
+}
@@ -0,0 +1,105 @@
+package function
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// Header names used to correlate a runner response with its request and to
+// propagate trace context, instead of overloading inbox_id in the body.
+const (
+	HeaderInboxId     = "Kubiya-Inbox-Id"
+	HeaderRunner      = "Kubiya-Runner"
+	HeaderTraceId     = "Kubiya-Trace-Id"
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// correlationHeaders builds the headers for a response message. traceParent
+// and traceState are forwarded as received on the inbound request so the
+// response stays in the same W3C trace; they're omitted if the caller didn't
+// send them.
+func correlationHeaders(inboxId, runner, traceParent, traceState string) nats.Header {
+	h := nats.Header{
+		HeaderInboxId: []string{inboxId},
+		HeaderRunner:  []string{runner},
+		HeaderTraceId: []string{newTraceId()},
+	}
+	if traceParent != "" {
+		h[HeaderTraceParent] = []string{traceParent}
+	}
+	if traceState != "" {
+		h[HeaderTraceState] = []string{traceState}
+	}
+	return h
+}
+
+// newTraceId generates a random 16-byte trace id, formatted the way W3C
+// traceparent expects it.
+func newTraceId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceContext carries the W3C trace headers from the inbound request
+// through to whichever Publisher ends up sending the response, without
+// widening the Publisher interface per backend.
+type traceContext struct {
+	parent string
+	state  string
+}
+
+type traceContextKey struct{}
+
+// withTraceContext attaches the inbound request's trace headers to ctx.
+func withTraceContext(ctx context.Context, traceParent, traceState string) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext{parent: traceParent, state: traceState})
+}
+
+// traceFromContext retrieves the trace headers attached by withTraceContext,
+// returning zero values if none were attached.
+func traceFromContext(ctx context.Context) (traceParent, traceState string) {
+	tc, _ := ctx.Value(traceContextKey{}).(traceContext)
+	return tc.parent, tc.state
+}
+
+// dedupeIDKey carries a publish-scoped id for server-side dedup (JetStream's
+// nats.MsgId), kept separate from the Kubiya-Inbox-Id correlation header so
+// chunking a single response into several envelopes doesn't collapse its
+// correlation id down to one chunk's id.
+type dedupeIDKey struct{}
+
+// withDedupeID attaches a dedup id to ctx, overriding the default of using
+// replyTo itself.
+func withDedupeID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, dedupeIDKey{}, id)
+}
+
+// dedupeIDFromContext retrieves the id attached by withDedupeID, if any.
+func dedupeIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(dedupeIDKey{}).(string)
+	return id, ok
+}
+
+// expectStreamKey carries the JetStream stream name a message's "stream"
+// field opted into, kept out of the Publisher interface the same way
+// dedupeIDKey is, so jetStreamPublisher.Publish can validate it without
+// every other backend needing to know it exists.
+type expectStreamKey struct{}
+
+// withExpectStream attaches the stream a publish should be validated
+// against (nats.ExpectStream) to ctx.
+func withExpectStream(ctx context.Context, stream string) context.Context {
+	return context.WithValue(ctx, expectStreamKey{}, stream)
+}
+
+// expectStreamFromContext retrieves the stream attached by withExpectStream,
+// if any.
+func expectStreamFromContext(ctx context.Context) (string, bool) {
+	stream, ok := ctx.Value(expectStreamKey{}).(string)
+	return stream, ok
+}
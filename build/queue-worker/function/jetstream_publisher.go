@@ -0,0 +1,158 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+const (
+	jetStreamRetryAttempts = 3
+	jetStreamRetryWait     = 200 * time.Millisecond
+	jetStreamAckTimeout    = 5 * time.Second
+)
+
+var (
+	jsOnce sync.Once
+	js     nats.JetStreamContext
+	jsErr  error
+)
+
+// jsContext returns the shared JetStreamContext, derived from the same
+// long-lived connection the core NATS publisher uses.
+func jsContext() (nats.JetStreamContext, error) {
+	jsOnce.Do(func() {
+		conn, err := natsConn()
+		if err != nil {
+			jsErr = fmt.Errorf("%w: %s", ErrBusUnavailable, err)
+			return
+		}
+		js, jsErr = conn.JetStream()
+	})
+	if jsErr != nil {
+		return nil, jsErr
+	}
+	if conn, err := natsConn(); err == nil && !conn.IsConnected() {
+		return nil, fmt.Errorf("%w: nats connection is %s", ErrBusUnavailable, conn.Status())
+	}
+	return js, nil
+}
+
+// jetStreamPublisher delivers runner responses through JetStream for
+// durable, at-least-once semantics instead of core NATS's best-effort publish.
+type jetStreamPublisher struct {
+	js nats.JetStreamContext
+}
+
+func newJetStreamPublisher() (Publisher, error) {
+	js, err := jsContext()
+	if err != nil {
+		return nil, err
+	}
+	return &jetStreamPublisher{js: js}, nil
+}
+
+func (p *jetStreamPublisher) Publish(ctx context.Context, subject, replyTo string, payload []byte) error {
+	traceParent, traceState := traceFromContext(ctx)
+	runner := strings.TrimSuffix(subject, ".response")
+	msg := &nats.Msg{
+		Subject: subject,
+		Header:  correlationHeaders(replyTo, runner, traceParent, traceState),
+		Data:    payload,
+	}
+	dedupeID := replyTo
+	if id, ok := dedupeIDFromContext(ctx); ok {
+		dedupeID = id
+	}
+	opts := []nats.PubOpt{
+		nats.MsgId(dedupeID),
+		nats.RetryAttempts(jetStreamRetryAttempts),
+		nats.RetryWait(jetStreamRetryWait),
+	}
+	if stream, ok := expectStreamFromContext(ctx); ok && stream != "" {
+		opts = append(opts, nats.ExpectStream(stream))
+	}
+	_, err := p.js.PublishMsg(msg, opts...)
+	return err
+}
+
+// jetStreamAck carries the stream/sequence JetStream assigned to a published
+// message, so callers can surface it in error responses or logs.
+type jetStreamAck struct {
+	Stream   string
+	Sequence uint64
+}
+
+// streamPublisher is implemented by jetStreamPublisher. It's the seam Handle
+// uses for the per-message "stream" opt-in, so tests can swap in a fake
+// without dialing a real JetStream connection.
+type streamPublisher interface {
+	Publisher
+	PublishStream(ctx context.Context, subject, inboxId, stream string, payload []byte) (*jetStreamAck, error)
+}
+
+// jetStreamForStream returns a JetStream-backed Publisher for the per-message
+// "stream" opt-in, reusing the same lazily-constructed JetStreamContext the
+// busJetStream backend uses. It refuses when the configured bus has no NATS
+// connection to build one from (KUBIYA_BUS=amqp), instead of silently
+// dialing NATS behind an operator's back who chose AMQP specifically to
+// avoid needing NGS access.
+func jetStreamForStream() (streamPublisher, error) {
+	if selectedBus() == busAMQP {
+		return nil, fmt.Errorf("%w: stream requires a NATS-backed bus, but KUBIYA_BUS=amqp is configured", ErrBusUnavailable)
+	}
+	pub, err := newJetStreamPublisher()
+	if err != nil {
+		return nil, err
+	}
+	return pub.(*jetStreamPublisher), nil
+}
+
+// jetStreamForStreamFn is a package-level indirection to jetStreamForStream,
+// so tests can stub it out the same way as getPublisherFn.
+var jetStreamForStreamFn = jetStreamForStream
+
+// PublishStream publishes payload through JetStream with server-side
+// deduplication on inboxId, ExpectStream validation, and bounded retries,
+// waiting at most jetStreamAckTimeout for the broker's ack and returning it,
+// where Publish waits without a bound and discards the ack. It backs the
+// per-message "stream" opt-in, which wants the ack back to report the
+// assigned sequence to the caller.
+func (p *jetStreamPublisher) PublishStream(ctx context.Context, subject, inboxId, stream string, payload []byte) (*jetStreamAck, error) {
+	opts := []nats.PubOpt{
+		nats.MsgId(inboxId),
+		nats.RetryAttempts(jetStreamRetryAttempts),
+		nats.RetryWait(jetStreamRetryWait),
+	}
+	if stream != "" {
+		opts = append(opts, nats.ExpectStream(stream))
+	}
+
+	traceParent, traceState := traceFromContext(ctx)
+	runner := strings.TrimSuffix(subject, ".response")
+	msg := &nats.Msg{
+		Subject: subject,
+		Header:  correlationHeaders(inboxId, runner, traceParent, traceState),
+		Data:    payload,
+	}
+
+	future, err := p.js.PublishMsgAsync(msg, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ack := <-future.Ok():
+		return &jetStreamAck{Stream: ack.Stream, Sequence: ack.Sequence}, nil
+	case err := <-future.Err():
+		return nil, err
+	case <-time.After(jetStreamAckTimeout):
+		return nil, fmt.Errorf("timed out after %s waiting for jetstream ack", jetStreamAckTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,114 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// busKind identifies which message-bus backend a Publisher talks to.
+type busKind string
+
+const (
+	busNATS      busKind = "nats"
+	busJetStream busKind = "jetstream"
+	busAMQP      busKind = "amqp"
+)
+
+// Publisher abstracts the message bus used to deliver a runner's response.
+// Implementations exist for core NATS, JetStream, and RabbitMQ (AMQP), so
+// the runner can be deployed in environments without NGS access.
+type Publisher interface {
+	Publish(ctx context.Context, subject, replyTo string, payload []byte) error
+}
+
+// ErrBusUnavailable wraps a Publish error caused by the underlying connection
+// being down (as opposed to a rejected or malformed publish), so callers can
+// tell the two apart and respond 503 instead of 500.
+var ErrBusUnavailable = fmt.Errorf("message bus unavailable")
+
+// publishStatusCode maps a Publish error to the HTTP status Handle should
+// return: 503 if the underlying bus was down, or fallback for any other
+// failure (malformed message, rejected publish, etc).
+func publishStatusCode(err error, fallback int) int {
+	if errors.Is(err, ErrBusUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return fallback
+}
+
+var (
+	publisherOnce sync.Once
+	publisher     Publisher
+	publisherErr  error
+)
+
+// selectedBus returns the configured bus backend, defaulting to core NATS to
+// preserve existing behavior when KUBIYA_BUS is unset.
+func selectedBus() busKind {
+	switch busKind(os.Getenv("KUBIYA_BUS")) {
+	case busJetStream:
+		return busJetStream
+	case busAMQP:
+		return busAMQP
+	default:
+		return busNATS
+	}
+}
+
+// getPublisher lazily constructs the Publisher for the configured bus and
+// reuses it across invocations, same as the underlying connections it wraps.
+func getPublisher() (Publisher, error) {
+	publisherOnce.Do(func() {
+		registerShutdownHandlerFn()
+		switch selectedBus() {
+		case busJetStream:
+			publisher, publisherErr = newJetStreamPublisher()
+		case busAMQP:
+			publisher, publisherErr = newAMQPPublisher()
+		default:
+			publisher, publisherErr = newNATSPublisher()
+		}
+	})
+	return publisher, publisherErr
+}
+
+// getPublisherFn is a package-level indirection to getPublisher, so tests
+// can stub it out and exercise Handle's branching without dialing a real
+// message bus.
+var getPublisherFn = getPublisher
+
+// natsPublisher publishes on the shared core-NATS connection.
+type natsPublisher struct{}
+
+func newNATSPublisher() (Publisher, error) {
+	if _, err := natsConn(); err != nil {
+		return nil, err
+	}
+	return natsPublisher{}, nil
+}
+
+func (natsPublisher) Publish(ctx context.Context, subject, replyTo string, payload []byte) error {
+	conn, err := natsConn()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBusUnavailable, err)
+	}
+	if !conn.IsConnected() {
+		return fmt.Errorf("%w: nats connection is %s", ErrBusUnavailable, conn.Status())
+	}
+
+	traceParent, traceState := traceFromContext(ctx)
+	runner := strings.TrimSuffix(subject, ".response")
+	msg := &nats.Msg{
+		Subject: subject,
+		Header:  correlationHeaders(replyTo, runner, traceParent, traceState),
+		Data:    payload,
+	}
+	return conn.PublishMsg(msg)
+}
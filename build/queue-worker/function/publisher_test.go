@@ -0,0 +1,25 @@
+package function
+
+import "testing"
+
+func TestSelectedBus(t *testing.T) {
+	tests := []struct {
+		name   string
+		envVal string
+		want   busKind
+	}{
+		{"empty defaults to nats", "", busNATS},
+		{"jetstream", "jetstream", busJetStream},
+		{"amqp", "amqp", busAMQP},
+		{"unrecognized value defaults to nats", "sqs", busNATS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBIYA_BUS", tt.envVal)
+			if got := selectedBus(); got != tt.want {
+				t.Fatalf("selectedBus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package function
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var shutdownHandlerOnce sync.Once
+
+// registerShutdownHandler installs a SIGTERM/SIGINT handler, once per
+// process, that drains whichever bus backend is active before exiting. The
+// OpenFaaS watchdog sends SIGTERM to the function process on scale-down/
+// redeploy; without a handler here the shared connection is dropped by the
+// runtime instead of drained, and any in-flight publish is lost.
+//
+// This is registered lazily from natsConn and getPublisher rather than in
+// an init(), since installing a process-wide signal handler at package init
+// time would also hijack SIGINT/SIGTERM for anything else that imports this
+// package, such as `go test`. It's called from both so the handler fires
+// regardless of which publish path a given deployment actually exercises:
+// natsConn is reached by the stream/KUBIYA_JETSTREAM=1 path via jsContext
+// without ever going through getPublisher.
+func registerShutdownHandler() {
+	shutdownHandlerOnce.Do(func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+		go func() {
+			sig := <-sigCh
+			logger.Info().Str("signal", sig.String()).Msg("shutting down, draining message bus")
+			if err := drainNATS(); err != nil {
+				logger.Error().Err(err).Msg("error draining nats connection")
+			}
+			if err := closeAMQP(); err != nil {
+				logger.Error().Err(err).Msg("error closing rabbitmq connection")
+			}
+			os.Exit(0)
+		}()
+	})
+}
+
+// registerShutdownHandlerFn is a package-level indirection to
+// registerShutdownHandler, so tests can stub it out and assert it's called
+// from a given path without installing a real signal handler.
+var registerShutdownHandlerFn = registerShutdownHandler
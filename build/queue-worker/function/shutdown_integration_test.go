@@ -0,0 +1,30 @@
+//go:build integration
+
+package function
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestNatsConnDialsRealNGS exercises the real natsConnectFn (tls://connect.
+// ngs.global) instead of a stub, so it's gated behind the integration tag
+// and requires valid NGS credentials at natsCredsFile — run explicitly with
+// `go test -tags=integration`, not as part of the default suite.
+func TestNatsConnDialsRealNGS(t *testing.T) {
+	origNc, origNcErr := nc, ncErr
+	ncOnce = sync.Once{}
+	nc, ncErr = nil, nil
+	t.Cleanup(func() {
+		ncOnce = sync.Once{}
+		nc, ncErr = origNc, origNcErr
+	})
+
+	conn, err := natsConn()
+	if err != nil {
+		t.Fatalf("natsConn() err = %v", err)
+	}
+	if !conn.IsConnected() {
+		t.Fatalf("natsConn() returned a connection that is %s, want connected", conn.Status())
+	}
+}
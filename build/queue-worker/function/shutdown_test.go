@@ -0,0 +1,43 @@
+package function
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// TestNatsConnRegistersShutdownHandler guards against the stream and
+// KUBIYA_JETSTREAM=1 paths silently skipping shutdown registration: they
+// reach natsConn via jsContext/jetStreamForStream without ever calling
+// getPublisher, so natsConn must install the handler itself regardless of
+// whether the dial that follows succeeds. natsConnectFn is stubbed so this
+// never dials the real NGS endpoint; see TestNatsConnDialsRealNGS (built
+// with -tags=integration) for a test that exercises the real dial.
+func TestNatsConnRegistersShutdownHandler(t *testing.T) {
+	origRegister := registerShutdownHandlerFn
+	var calls int
+	registerShutdownHandlerFn = func() { calls++ }
+	t.Cleanup(func() { registerShutdownHandlerFn = origRegister })
+
+	origConnect := natsConnectFn
+	natsConnectFn = func(_ string, _ ...nats.Option) (*nats.Conn, error) {
+		return nil, errors.New("stubbed dial")
+	}
+	t.Cleanup(func() { natsConnectFn = origConnect })
+
+	origNc, origNcErr := nc, ncErr
+	ncOnce = sync.Once{}
+	nc, ncErr = nil, nil
+	t.Cleanup(func() {
+		ncOnce = sync.Once{}
+		nc, ncErr = origNc, origNcErr
+	})
+
+	natsConn()
+
+	if calls != 1 {
+		t.Fatalf("natsConn() called registerShutdownHandlerFn %d times, want 1", calls)
+	}
+}